@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"file-transfer/messages"
+	"file-transfer/util"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// partSweepInterval and partTTL bound how long an abandoned .part/.meta pair
+// is kept around before the sweeper reclaims it.
+const (
+	partSweepInterval = 10 * time.Minute
+	partTTL           = 24 * time.Hour
+)
+
+// maxChunkSize bounds the per-chunk allocation in receiveChunks so a client
+// can't make the server attempt a single huge make([]byte, ...) by setting
+// ChunkSize to something absurd.
+const maxChunkSize = 64 * 1024 * 1024 // 64 MiB
+
+// chunkMeta is the sidecar JSON persisted next to a <FileID>.part file. It
+// records enough state to resume an interrupted upload without re-reading
+// the partial file.
+type chunkMeta struct {
+	FileName  string           `json:"file_name"`
+	TotalSize uint64           `json:"total_size"`
+	ChunkSize uint64           `json:"chunk_size"`
+	Received  map[int64]string `json:"received"` // chunk index -> hex MD5
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+func partPath(fileID string) string { return fileID + ".part" }
+func metaPath(fileID string) string { return fileID + ".meta" }
+
+func loadChunkMeta(fileID string) (*chunkMeta, error) {
+	data, err := os.ReadFile(metaPath(fileID))
+	if err != nil {
+		return nil, err
+	}
+	meta := &chunkMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func saveChunkMeta(fileID string, meta *chunkMeta) error {
+	meta.UpdatedAt = time.Now()
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath(fileID), data, 0666)
+}
+
+func totalChunks(totalSize, chunkSize uint64) int64 {
+	if chunkSize == 0 {
+		return 0
+	}
+	return int64((totalSize + chunkSize - 1) / chunkSize)
+}
+
+// handleChunkedStorage begins or continues a resumable upload identified by
+// request.FileID. The client streams chunks one at a time; each chunk is
+// announced by a ChunkReq header followed by its body, mirroring the
+// header-then-body pattern used by handleStorage.
+func handleChunkedStorage(msgHandler *messages.MessageHandler, request *messages.ChunkedStorageReq) {
+	fileID := request.FileId
+	log.Println("Starting chunked upload", fileID, "for", request.FileName)
+
+	if request.ChunkSize == 0 || request.ChunkSize > maxChunkSize {
+		msgHandler.SendResponse(false, "Invalid chunk size")
+		msgHandler.Close()
+		return
+	}
+	if err := checkDiskSpace(request.TotalSize); err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+
+	fileName, err := SafePath(".", request.FileName)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+
+	meta := &chunkMeta{
+		FileName:  fileName,
+		TotalSize: request.TotalSize,
+		ChunkSize: request.ChunkSize,
+		Received:  make(map[int64]string),
+	}
+	if existing, err := loadChunkMeta(fileID); err == nil {
+		meta = existing
+	}
+	if err := saveChunkMeta(fileID, meta); err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+
+	msgHandler.SendResponse(true, "Ready for chunks")
+	receiveChunks(msgHandler, fileID, meta)
+}
+
+// handleResume reports which chunks of an in-progress upload have already
+// been received so the client can retransmit only what's missing, then
+// continues receiving chunks.
+func handleResume(msgHandler *messages.MessageHandler, request *messages.ResumeReq) {
+	fileID := request.FileId
+	meta, err := loadChunkMeta(fileID)
+	if err != nil {
+		msgHandler.SendResumeBitmap(false, "Unknown file ID", nil)
+		msgHandler.Close()
+		return
+	}
+
+	count := totalChunks(meta.TotalSize, meta.ChunkSize)
+	bitmap := make([]bool, count)
+	for idx := range bitmap {
+		_, ok := meta.Received[int64(idx)]
+		bitmap[idx] = ok
+	}
+
+	log.Println("Resuming upload", fileID, "-", len(meta.Received), "of", count, "chunks already received")
+	msgHandler.SendResumeBitmap(true, "Resuming", bitmap)
+	receiveChunks(msgHandler, fileID, meta)
+}
+
+// receiveChunks reads ChunkReq headers and bodies from msgHandler until the
+// upload is complete, verifying each chunk's MD5 before recording it.
+func receiveChunks(msgHandler *messages.MessageHandler, fileID string, meta *chunkMeta) {
+	file, err := os.OpenFile(partPath(fileID), os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+	defer file.Close()
+
+	count := totalChunks(meta.TotalSize, meta.ChunkSize)
+	for int64(len(meta.Received)) < count {
+		wrapper, err := msgHandler.Receive()
+		if err != nil {
+			log.Println("Error receiving chunk:", err)
+			return
+		}
+		chunkReq := wrapper.GetChunkReq()
+		if chunkReq == nil || chunkReq.FileId != fileID {
+			log.Println("Unexpected message while receiving chunks")
+			return
+		}
+
+		if chunkReq.Offset >= meta.TotalSize || chunkReq.Offset%meta.ChunkSize != 0 {
+			msgHandler.SendChunkAck(false, "Chunk offset out of range")
+			return
+		}
+
+		size := chunkSizeFor(chunkReq.Offset, meta)
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(msgHandler, buf); err != nil {
+			log.Println("Error reading chunk body:", err)
+			return
+		}
+
+		md5Sum := md5.Sum(buf)
+		if !util.VerifyChecksum(md5Sum[:], chunkReq.Md5) {
+			msgHandler.SendChunkAck(false, "Chunk checksum mismatch")
+			continue
+		}
+		if _, err := file.WriteAt(buf, int64(chunkReq.Offset)); err != nil {
+			msgHandler.SendChunkAck(false, err.Error())
+			return
+		}
+
+		index := int64(chunkReq.Offset / meta.ChunkSize)
+		meta.Received[index] = hex.EncodeToString(md5Sum[:])
+		if err := saveChunkMeta(fileID, meta); err != nil {
+			msgHandler.SendChunkAck(false, err.Error())
+			return
+		}
+		msgHandler.SendChunkAck(true, "Chunk stored")
+	}
+
+	finishChunkedUpload(msgHandler, fileID, meta)
+}
+
+// chunkSizeFor returns the number of bytes expected in the chunk starting at
+// offset, accounting for the final, possibly short, chunk.
+func chunkSizeFor(offset uint64, meta *chunkMeta) uint64 {
+	remaining := meta.TotalSize - offset
+	if remaining < meta.ChunkSize {
+		return remaining
+	}
+	return meta.ChunkSize
+}
+
+// finishChunkedUpload verifies the whole-file checksum once every chunk has
+// arrived and, on success, renames the .part file into place and removes
+// the sidecar metadata.
+func finishChunkedUpload(msgHandler *messages.MessageHandler, fileID string, meta *chunkMeta) {
+	finalCheck, err := util.MD5File(partPath(fileID))
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+
+	clientCheckMsg, err := msgHandler.Receive()
+	if err != nil {
+		log.Println("Error receiving final checksum:", err)
+		return
+	}
+	clientCheck := clientCheckMsg.GetChecksum().Checksum
+
+	if !util.VerifyChecksum(finalCheck, clientCheck) {
+		log.Println("FAILED chunked upload. Final checksum mismatch for", meta.FileName)
+		msgHandler.SendResponse(false, "Final checksum verification failed")
+		return
+	}
+
+	if dir := filepath.Dir(meta.FileName); dir != "." {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			msgHandler.SendResponse(false, err.Error())
+			return
+		}
+	}
+	if err := os.Rename(partPath(fileID), meta.FileName); err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+	os.Remove(metaPath(fileID))
+
+	log.Println("Successfully stored chunked upload as", meta.FileName)
+	msgHandler.SendResponse(true, "File stored successfully")
+}
+
+// startPartSweeper launches a background goroutine that periodically
+// deletes .part/.meta pairs whose metadata hasn't been touched in partTTL,
+// reclaiming space from abandoned resumable uploads.
+func startPartSweeper(dir string, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(partSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sweepAbandonedParts(dir)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func sweepAbandonedParts(dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.meta"))
+	if err != nil {
+		return
+	}
+	for _, metaFile := range matches {
+		info, err := os.Stat(metaFile)
+		if err != nil || time.Since(info.ModTime()) < partTTL {
+			continue
+		}
+		fileID := metaFile[:len(metaFile)-len(".meta")]
+		log.Println("Sweeping abandoned chunked upload", fileID)
+		os.Remove(partPath(fileID))
+		os.Remove(metaFile)
+	}
+}
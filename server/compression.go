@@ -0,0 +1,118 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"file-transfer/messages"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// newDecompressReader wraps r so reads return the decompressed bytes of a
+// stream written with the given codec. For Compression_NONE it returns r
+// unchanged.
+func newDecompressReader(codec messages.Compression, r io.Reader) (io.Reader, error) {
+	switch codec {
+	case messages.Compression_NONE:
+		return r, nil
+	case messages.Compression_GZIP:
+		return gzip.NewReader(r)
+	case messages.Compression_ZSTD:
+		return zstd.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression codec: %v", codec)
+	}
+}
+
+// compressFileToTemp compresses the file at path with the given codec into
+// a temporary file, computing the MD5 of the *uncompressed* bytes along the
+// way so the logical file is what gets checksum-verified. It returns the
+// temp file's path (caller must remove it) and its compressed size, which
+// lets handleRetrieval report accurate progress to the client before
+// streaming begins.
+func compressFileToTemp(path string, codec messages.Compression) (tempPath string, md5Sum []byte, compressedSize int64, err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	defer src.Close()
+
+	temp, err := os.CreateTemp("", "ft-compress-*")
+	if err != nil {
+		return "", nil, 0, err
+	}
+	defer temp.Close()
+
+	hasher := md5.New()
+	tee := io.TeeReader(src, hasher)
+
+	var compressor io.WriteCloser
+	switch codec {
+	case messages.Compression_GZIP:
+		compressor = gzip.NewWriter(temp)
+	case messages.Compression_ZSTD:
+		compressor, err = zstd.NewWriter(temp)
+		if err != nil {
+			os.Remove(temp.Name())
+			return "", nil, 0, err
+		}
+	default:
+		os.Remove(temp.Name())
+		return "", nil, 0, fmt.Errorf("unsupported compression codec: %v", codec)
+	}
+
+	if _, err := io.Copy(compressor, tee); err != nil {
+		compressor.Close()
+		os.Remove(temp.Name())
+		return "", nil, 0, err
+	}
+	if err := compressor.Close(); err != nil {
+		os.Remove(temp.Name())
+		return "", nil, 0, err
+	}
+
+	info, err := temp.Stat()
+	if err != nil {
+		os.Remove(temp.Name())
+		return "", nil, 0, err
+	}
+
+	return temp.Name(), hasher.Sum(nil), info.Size(), nil
+}
+
+// receiveCompressedBody reads exactly request.CompressedSize raw bytes from
+// msgHandler into a temp file, then decompresses from that temp file into
+// dest, hashing as it goes. Decompressing straight off msgHandler would let
+// gzip/zstd's internal read-ahead buffering consume bytes belonging to the
+// next wire message (the client's ChecksumVerification, or the next
+// request on a reused connection); routing through a temp file first means
+// the decompressor only ever sees exactly the compressed payload and can't
+// over-read the shared connection.
+func receiveCompressedBody(msgHandler *messages.MessageHandler, request *messages.StorageRequest, dest *os.File, hasher hash.Hash) error {
+	temp, err := os.CreateTemp("", "ft-upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	if _, err := io.CopyN(temp, msgHandler, int64(request.CompressedSize)); err != nil {
+		return err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	decompressed, err := newDecompressReader(request.Compression, temp)
+	if err != nil {
+		return err
+	}
+
+	w := io.MultiWriter(dest, hasher)
+	_, err = io.CopyN(w, decompressed, int64(request.Size))
+	return err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/md5"
+	"file-transfer/messages"
+	"file-transfer/util"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// receiveFileBody reads size bytes from msgHandler into a newly created file
+// at path, computing an MD5 checksum as it goes. handleDirectoryStorage
+// calls it once per manifest entry.
+func receiveFileBody(msgHandler *messages.MessageHandler, path string, size uint64) ([]byte, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	md5Sum := md5.New()
+	w := io.MultiWriter(file, md5Sum)
+	io.CopyN(w, msgHandler, int64(size))
+
+	return md5Sum.Sum(nil), nil
+}
+
+// sendFileBody streams the file at path to msgHandler, computing an MD5
+// checksum as it goes. handleDirectoryRetrieval calls it once per manifest
+// entry.
+func sendFileBody(msgHandler *messages.MessageHandler, path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	md5Sum := md5.New()
+	w := io.MultiWriter(msgHandler, md5Sum)
+	io.CopyN(w, file, info.Size())
+
+	return md5Sum.Sum(nil), nil
+}
+
+// handleDirectoryStorage stores an entire directory tree uploaded by a
+// client. The client first describes every file it intends to send via
+// request.Manifest (relative path, size and MD5), then streams each file's
+// body in manifest order using the same checksum-verify logic as
+// handleStorage.
+func handleDirectoryStorage(msgHandler *messages.MessageHandler, request *messages.DirectoryStorageReq) {
+	root, err := SafePath(".", request.RootName)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+	log.Println("Attempting to store directory", root)
+
+	destPaths := make([]string, len(request.Manifest))
+	var totalSize uint64
+	for i, entry := range request.Manifest {
+		destPath, err := SafePath(root, entry.RelPath)
+		if err != nil {
+			msgHandler.SendResponse(false, "Manifest entry escapes storage root: "+entry.RelPath)
+			msgHandler.Close()
+			return
+		}
+		destPaths[i] = destPath
+		totalSize += entry.Size
+	}
+
+	if err := checkDiskSpace(totalSize); err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+
+	msgHandler.SendResponse(true, "Ready for manifest data")
+
+	for i, entry := range request.Manifest {
+		destPath := destPaths[i]
+
+		serverCheck, err := receiveFileBody(msgHandler, destPath, entry.Size)
+		if err != nil {
+			log.Println("Error receiving", entry.RelPath, ":", err)
+			msgHandler.SendResponse(false, err.Error())
+			return
+		}
+
+		if !util.VerifyChecksum(serverCheck, entry.Md5) {
+			log.Println("FAILED to store", entry.RelPath, ". Invalid checksum.")
+			os.Remove(destPath)
+			msgHandler.SendResponse(false, "Checksum verification failed for "+entry.RelPath)
+			return
+		}
+	}
+
+	log.Println("Successfully stored directory.")
+	msgHandler.SendResponse(true, "Directory stored successfully")
+}
+
+// handleDirectoryRetrieval walks the requested directory and sends a
+// manifest back to the client, then streams each file's body in manifest
+// order so the client can reconstruct the original layout.
+func handleDirectoryRetrieval(msgHandler *messages.MessageHandler, request *messages.DirectoryRetrievalReq) {
+	root, err := SafePath(".", request.RootName)
+	if err != nil {
+		msgHandler.SendDirectoryManifest(false, err.Error(), nil)
+		msgHandler.Close()
+		return
+	}
+	log.Println("Attempting to retrieve directory", root)
+
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		log.Println("Directory not found:", err)
+		msgHandler.SendDirectoryManifest(false, "Directory not found", nil)
+		msgHandler.Close()
+		return
+	}
+
+	var manifest []*messages.ManifestEntry
+	err = filepath.Walk(root, func(path string, fileInfo os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if fileInfo.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		md5Sum, err := util.MD5File(path)
+		if err != nil {
+			return err
+		}
+
+		manifest = append(manifest, &messages.ManifestEntry{
+			RelPath: filepath.ToSlash(relPath),
+			Size:    uint64(fileInfo.Size()),
+			Md5:     md5Sum,
+		})
+		return nil
+	})
+	if err != nil {
+		log.Println("Error walking directory:", err)
+		msgHandler.SendDirectoryManifest(false, err.Error(), nil)
+		msgHandler.Close()
+		return
+	}
+
+	msgHandler.SendDirectoryManifest(true, "Ready to send", manifest)
+
+	for _, entry := range manifest {
+		srcPath := filepath.Join(root, filepath.FromSlash(entry.RelPath))
+
+		checksum, err := sendFileBody(msgHandler, srcPath)
+		if err != nil {
+			log.Println("Error sending", entry.RelPath, ":", err)
+			return
+		}
+		msgHandler.SendChecksumVerification(checksum)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"file-transfer/messages"
+	"file-transfer/util"
+	"log"
+)
+
+// negotiateHello performs the handshake exchanged at the start of every
+// connection, before the regular request/response loop begins. The client
+// announces the hash algorithms and compression codecs it supports, and
+// the server replies with its own supported sets so the client can pick
+// the strongest mutually supported options for subsequent
+// StorageRequest/RetrievalRequest messages. A client that leaves HashAlgo
+// or Compression unset on those requests still gets plain MD5 and no
+// compression.
+//
+// If the first message isn't a Hello at all, the client predates the
+// handshake; it's returned as firstMsg so handleClient can dispatch it
+// through the normal switch instead of dropping the connection, keeping
+// the handshake backward compatible.
+func negotiateHello(msgHandler *messages.MessageHandler) (firstMsg *messages.Wrapper, ok bool) {
+	wrapper, err := msgHandler.Receive()
+	if err != nil {
+		log.Println("Error receiving hello:", err)
+		return nil, false
+	}
+
+	hello := wrapper.GetHello()
+	if hello == nil {
+		return wrapper, true
+	}
+
+	log.Println("Client supports hash algorithms:", hello.SupportedHashAlgos, "and codecs:", hello.SupportedCodecs)
+	msgHandler.SendHello(util.SupportedHashAlgos(), util.SupportedCodecs())
+	return nil, true
+}
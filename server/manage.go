@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/md5"
+	"file-transfer/messages"
+	"file-transfer/util"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// resolveWithinRoot resolves relPath against the server's storage root,
+// rejecting traversal and symlink escapes. It's a thin alias over SafePath
+// kept for readability at the LIST/STAT/DELETE call sites.
+func resolveWithinRoot(relPath string) (string, error) {
+	return SafePath(".", relPath)
+}
+
+// handleList streams directory entries rooted at request.Prefix back to the
+// client, descending into subdirectories when request.Recursive is set.
+func handleList(msgHandler *messages.MessageHandler, request *messages.ListReq) {
+	root, err := resolveWithinRoot(request.Prefix)
+	if err != nil {
+		msgHandler.SendListResponse(false, err.Error(), nil)
+		return
+	}
+
+	var entries []*messages.FileEntry
+	walkFn := func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == root {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &messages.FileEntry{
+			Name:  filepath.ToSlash(relPath),
+			Size:  uint64(info.Size()),
+			Mtime: info.ModTime().Unix(),
+			Mode:  uint32(info.Mode()),
+			IsDir: info.IsDir(),
+		})
+		if info.IsDir() && !request.Recursive && path != root {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	if err := filepath.Walk(root, walkFn); err != nil {
+		log.Println("Error listing", request.Prefix, ":", err)
+		msgHandler.SendListResponse(false, err.Error(), nil)
+		return
+	}
+
+	msgHandler.SendListResponse(true, "OK", entries)
+}
+
+// handleStat reports metadata for a single file or directory.
+func handleStat(msgHandler *messages.MessageHandler, request *messages.StatReq) {
+	path, err := resolveWithinRoot(request.FileName)
+	if err != nil {
+		msgHandler.SendStatResponse(false, err.Error(), nil)
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		msgHandler.SendStatResponse(false, "File not found", nil)
+		return
+	}
+
+	msgHandler.SendStatResponse(true, "OK", &messages.FileEntry{
+		Name:  request.FileName,
+		Size:  uint64(info.Size()),
+		Mtime: info.ModTime().Unix(),
+		Mode:  uint32(info.Mode()),
+		IsDir: info.IsDir(),
+	})
+}
+
+// handleDelete removes a file after confirming the caller knows its current
+// contents, guarding against deleting the wrong file in a race with a
+// concurrent overwrite.
+func handleDelete(msgHandler *messages.MessageHandler, request *messages.DeleteReq) {
+	path, err := resolveWithinRoot(request.FileName)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		msgHandler.SendResponse(false, "File not found")
+		return
+	}
+	md5Sum := md5.New()
+	_, err = io.Copy(md5Sum, file)
+	file.Close()
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+
+	if !util.VerifyChecksum(md5Sum.Sum(nil), request.Md5) {
+		msgHandler.SendResponse(false, "Checksum does not match current file contents")
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+
+	log.Println("Deleted", request.FileName)
+	msgHandler.SendResponse(true, "File deleted")
+}
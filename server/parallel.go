@@ -0,0 +1,211 @@
+package main
+
+import (
+	"file-transfer/messages"
+	"file-transfer/util"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// parallelSessionTimeout bounds how long a control connection waits for
+// every satellite stream to attach before giving up. Without it, a client
+// that opens fewer than Streams connections (dropped link, crash) would
+// leak the goroutine, the open *os.File, and the session map entry
+// forever.
+const parallelSessionTimeout = 2 * time.Minute
+
+// maxStreams bounds request.Streams so make([]bool, request.Streams) in
+// handleParallelStorage can't be forced into a multi-gigabyte allocation
+// by an absurd stream count, the same DoS class maxChunkSize guards
+// against for chunked uploads.
+const maxStreams = 256
+
+// attachCopyChunkSize bounds how much of an AttachReq's range is buffered
+// in memory at once in handleAttach, so a single attach covering most of a
+// large file can't force an allocation as large as the whole file.
+const attachCopyChunkSize = 32 * 1024
+
+// parallelSession tracks an in-progress multi-stream upload between the
+// time the control connection opens it and the time every satellite
+// stream has attached and written its range.
+type parallelSession struct {
+	mu       sync.Mutex
+	file     *os.File
+	path     string
+	size     uint64
+	streams  int
+	received []bool
+	done     chan struct{}
+}
+
+func (s *parallelSession) markReceived(streamIndex int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if streamIndex < 0 || streamIndex >= len(s.received) || s.received[streamIndex] {
+		return
+	}
+	s.received[streamIndex] = true
+
+	for _, ok := range s.received {
+		if !ok {
+			return
+		}
+	}
+	close(s.done)
+}
+
+var (
+	parallelSessionsMu sync.Mutex
+	parallelSessions   = make(map[string]*parallelSession)
+)
+
+// handleParallelStorage opens a parallel-stream upload on the control
+// connection. It allocates the file up front (truncated to its final size
+// so satellite streams can WriteAt arbitrary offsets concurrently) and
+// blocks until every stream has attached and the client sends the
+// whole-file checksum to verify against.
+func handleParallelStorage(msgHandler *messages.MessageHandler, request *messages.ParallelStorageReq) {
+	path, err := SafePath(".", request.FileName)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+	log.Println("Attempting parallel store of", path, "across", request.Streams, "streams")
+
+	if request.Streams <= 0 || request.Streams > maxStreams {
+		msgHandler.SendResponse(false, fmt.Sprintf("Streams must be between 1 and %d", maxStreams))
+		msgHandler.Close()
+		return
+	}
+
+	if err := checkDiskSpace(request.Size); err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+	if err := file.Truncate(int64(request.Size)); err != nil {
+		file.Close()
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
+
+	sessionID := util.NewUUID()
+	session := &parallelSession{
+		file:     file,
+		path:     path,
+		size:     request.Size,
+		streams:  int(request.Streams),
+		received: make([]bool, request.Streams),
+		done:     make(chan struct{}),
+	}
+
+	parallelSessionsMu.Lock()
+	parallelSessions[sessionID] = session
+	parallelSessionsMu.Unlock()
+
+	msgHandler.SendParallelStorageResponse(true, "Ready", sessionID)
+
+	select {
+	case <-session.done:
+	case <-time.After(parallelSessionTimeout):
+		log.Println("Timed out waiting for all streams to attach to session", sessionID)
+		parallelSessionsMu.Lock()
+		delete(parallelSessions, sessionID)
+		parallelSessionsMu.Unlock()
+		file.Close()
+		os.Remove(path)
+		msgHandler.SendResponse(false, "Timed out waiting for all streams to attach")
+		return
+	}
+	file.Close()
+
+	parallelSessionsMu.Lock()
+	delete(parallelSessions, sessionID)
+	parallelSessionsMu.Unlock()
+
+	serverCheck, err := util.MD5File(path)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		return
+	}
+
+	clientCheckMsg, err := msgHandler.Receive()
+	if err != nil {
+		log.Println("Error receiving checksum:", err)
+		os.Remove(path)
+		return
+	}
+	clientCheck := clientCheckMsg.GetChecksum().Checksum
+
+	if util.VerifyChecksum(serverCheck, clientCheck) {
+		log.Println("Successfully stored file via parallel transfer.")
+		msgHandler.SendResponse(true, "File stored successfully")
+	} else {
+		log.Println("FAILED parallel transfer. Invalid checksum.")
+		os.Remove(path)
+		msgHandler.SendResponse(false, "Checksum verification failed")
+	}
+}
+
+// handleAttach is the entry point for a satellite connection joining an
+// already-open parallel session: it writes its assigned byte range at the
+// given offset and acknowledges once stored.
+func handleAttach(msgHandler *messages.MessageHandler, request *messages.AttachReq) {
+	parallelSessionsMu.Lock()
+	session, ok := parallelSessions[request.SessionId]
+	parallelSessionsMu.Unlock()
+	if !ok {
+		msgHandler.SendResponse(false, "Unknown session ID")
+		msgHandler.Close()
+		return
+	}
+
+	if request.Offset >= session.size || request.Length > session.size-request.Offset {
+		msgHandler.SendResponse(false, "Range out of bounds for this session")
+		msgHandler.Close()
+		return
+	}
+
+	bufSize := attachCopyChunkSize
+	if request.Length < uint64(bufSize) {
+		bufSize = int(request.Length)
+	}
+	buf := make([]byte, bufSize)
+
+	offset := request.Offset
+	remaining := request.Length
+	for remaining > 0 {
+		n := len(buf)
+		if remaining < uint64(n) {
+			n = int(remaining)
+		}
+		if _, err := io.ReadFull(msgHandler, buf[:n]); err != nil {
+			log.Println("Error reading attached stream body:", err)
+			msgHandler.SendResponse(false, err.Error())
+			return
+		}
+		if _, err := session.file.WriteAt(buf[:n], int64(offset)); err != nil {
+			msgHandler.SendResponse(false, err.Error())
+			return
+		}
+		offset += uint64(n)
+		remaining -= uint64(n)
+	}
+
+	session.markReceived(int(request.StreamIndex))
+	msgHandler.SendResponse(true, "Range stored")
+}
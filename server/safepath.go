@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrPathEscape is returned by SafePath when a client-supplied path, once
+// resolved, would fall outside the storage root.
+var ErrPathEscape = errors.New("resolved path escapes storage root")
+
+// ErrNotEnoughDiskSpace is returned when the storage volume does not have
+// enough free space to hold an incoming upload.
+var ErrNotEnoughDiskSpace = errors.New("not enough disk space")
+
+// checkDiskSpace reports ErrNotEnoughDiskSpace if the storage volume does
+// not have at least size bytes free. Shared by every handler that creates
+// a new file up front (single-stream and parallel storage alike).
+func checkDiskSpace(size uint64) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(".", &stat); err != nil {
+		return err
+	}
+	if stat.Bavail*uint64(stat.Bsize) < size {
+		return ErrNotEnoughDiskSpace
+	}
+	return nil
+}
+
+// SafePath resolves a client-supplied path against root, guaranteeing the
+// result is a descendant of root even in the presence of ".." segments,
+// absolute paths, or symlinks planted partway down the tree. It walks the
+// path one segment at a time, calling os.Lstat so it can detect a symlink
+// before following it, rather than trusting a single resolved-path check
+// at the end.
+func SafePath(root, rawPath string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	// Rooting the cleaned path at "/" before cleaning collapses any leading
+	// ".." segments instead of letting them climb above root.
+	cleaned := filepath.Clean(string(filepath.Separator) + rawPath)
+	rel := strings.TrimPrefix(cleaned, string(filepath.Separator))
+
+	current := root
+	if rel != "" {
+		for _, segment := range strings.Split(rel, string(filepath.Separator)) {
+			if segment == "" || segment == "." {
+				continue
+			}
+			next := filepath.Join(current, segment)
+
+			info, err := os.Lstat(next)
+			if os.IsNotExist(err) {
+				// The rest of the path doesn't exist yet (e.g. a file being
+				// created); nothing further to resolve.
+				current = next
+				break
+			} else if err != nil {
+				return "", err
+			}
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := filepath.EvalSymlinks(next)
+				if err != nil {
+					return "", err
+				}
+				if target != root && !strings.HasPrefix(target, root+string(filepath.Separator)) {
+					return "", ErrPathEscape
+				}
+				next = target
+			}
+			current = next
+		}
+	}
+
+	joined := filepath.Join(root, rel)
+	if joined != root && !strings.HasPrefix(joined, root+string(filepath.Separator)) {
+		return "", ErrPathEscape
+	}
+	return joined, nil
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/md5"
 	"file-transfer/messages"
 	"file-transfer/util"
 	"fmt"
@@ -10,12 +9,15 @@ import (
 	"net"
 	"os"
 	"path/filepath"
-	"syscall"
 )
 
 func handleStorage(msgHandler *messages.MessageHandler, request *messages.StorageRequest) {
-	// Extract only the base filename (no directories)
-	fileName := filepath.Base(request.FileName)
+	fileName, err := SafePath(".", request.FileName)
+	if err != nil {
+		msgHandler.SendResponse(false, err.Error())
+		msgHandler.Close()
+		return
+	}
 	log.Println("Attempting to store", fileName)
 
 	// Check if file already exists
@@ -26,17 +28,18 @@ func handleStorage(msgHandler *messages.MessageHandler, request *messages.Storag
 	}
 
 	// Check available disk space
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(".", &stat); err != nil {
-		msgHandler.SendResponse(false, "Cannot check disk space")
+	if err := checkDiskSpace(request.Size); err != nil {
+		msgHandler.SendResponse(false, err.Error())
 		msgHandler.Close()
 		return
 	}
-	availableSpace := stat.Bavail * uint64(stat.Bsize)
-	if availableSpace < request.Size {
-		msgHandler.SendResponse(false, "Insufficient disk space")
-		msgHandler.Close()
-		return
+
+	if dir := filepath.Dir(fileName); dir != "." {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			msgHandler.SendResponse(false, err.Error())
+			msgHandler.Close()
+			return
+		}
 	}
 
 	// Create the file
@@ -47,14 +50,30 @@ func handleStorage(msgHandler *messages.MessageHandler, request *messages.Storag
 		return
 	}
 
+	hasher, err := util.NewHash(request.HashAlgo)
+	if err != nil {
+		log.Println("Unsupported hash algorithm, falling back to MD5:", err)
+		hasher, _ = util.NewHash(messages.HashAlgo_MD5)
+	}
+
 	// Send OK response to client
 	msgHandler.SendResponse(true, "Ready for data")
-	md5 := md5.New()
-	w := io.MultiWriter(file, md5)
-	io.CopyN(w, msgHandler, int64(request.Size)) /* Write and checksum as we go */
+
+	if request.Compression != messages.Compression_NONE {
+		err = receiveCompressedBody(msgHandler, request, file, hasher)
+	} else {
+		w := io.MultiWriter(file, hasher)
+		_, err = io.CopyN(w, msgHandler, int64(request.Size)) /* Write and checksum as we go */
+	}
 	file.Close()
+	if err != nil {
+		log.Println("Error receiving file body:", err)
+		msgHandler.SendResponse(false, err.Error())
+		os.Remove(fileName)
+		return
+	}
 
-	serverCheck := md5.Sum(nil)
+	serverCheck := hasher.Sum(nil)
 
 	// Receive client's checksum
 	clientCheckMsg, err := msgHandler.Receive()
@@ -77,20 +96,29 @@ func handleStorage(msgHandler *messages.MessageHandler, request *messages.Storag
 }
 
 func handleRetrieval(msgHandler *messages.MessageHandler, request *messages.RetrievalRequest) {
-	// Extract only the base filename (no directories)
-	fileName := filepath.Base(request.FileName)
+	fileName, err := SafePath(".", request.FileName)
+	if err != nil {
+		msgHandler.SendRetrievalResponse(false, err.Error(), 0, 0)
+		msgHandler.Close()
+		return
+	}
 	log.Println("Attempting to retrieve", fileName)
 
 	// Get file size and make sure it exists
 	info, err := os.Stat(fileName)
 	if err != nil {
 		log.Println("File not found:", err)
-		msgHandler.SendRetrievalResponse(false, "File not found", 0)
+		msgHandler.SendRetrievalResponse(false, "File not found", 0, 0)
 		msgHandler.Close()
 		return
 	}
 
-	msgHandler.SendRetrievalResponse(true, "Ready to send", uint64(info.Size()))
+	if request.Compression != messages.Compression_NONE {
+		handleCompressedRetrieval(msgHandler, fileName, request)
+		return
+	}
+
+	msgHandler.SendRetrievalResponse(true, "Ready to send", uint64(info.Size()), uint64(info.Size()))
 
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -99,21 +127,84 @@ func handleRetrieval(msgHandler *messages.MessageHandler, request *messages.Retr
 	}
 	defer file.Close()
 
-	md5 := md5.New()
-	w := io.MultiWriter(msgHandler, md5)
+	hasher, err := util.NewHash(request.HashAlgo)
+	if err != nil {
+		log.Println("Unsupported hash algorithm, falling back to MD5:", err)
+		hasher, _ = util.NewHash(messages.HashAlgo_MD5)
+	}
+	w := io.MultiWriter(msgHandler, hasher)
 	io.CopyN(w, file, info.Size()) // Checksum and transfer file at same time
 
-	checksum := md5.Sum(nil)
+	checksum := hasher.Sum(nil)
+	msgHandler.SendChecksumVerification(checksum)
+}
+
+// handleCompressedRetrieval compresses fileName to a temporary file so the
+// compressed size is known up front, reports it to the client in the
+// retrieval response, then streams the compressed bytes as-is. The checksum
+// sent afterwards is computed over the original, uncompressed bytes with
+// request.HashAlgo (the MD5 from compressFileToTemp when that is MD5 or
+// unsupported), so it still describes the logical file.
+func handleCompressedRetrieval(msgHandler *messages.MessageHandler, fileName string, request *messages.RetrievalRequest) {
+	tempPath, md5Sum, compressedSize, err := compressFileToTemp(fileName, request.Compression)
+	if err != nil {
+		log.Println("Error compressing", fileName, ":", err)
+		msgHandler.SendRetrievalResponse(false, err.Error(), 0, 0)
+		msgHandler.Close()
+		return
+	}
+	defer os.Remove(tempPath)
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		msgHandler.SendRetrievalResponse(false, err.Error(), 0, 0)
+		msgHandler.Close()
+		return
+	}
+	msgHandler.SendRetrievalResponse(true, "Ready to send", uint64(info.Size()), uint64(compressedSize))
+
+	temp, err := os.Open(tempPath)
+	if err != nil {
+		log.Println("Error opening compressed temp file:", err)
+		return
+	}
+	defer temp.Close()
+
+	io.Copy(msgHandler, temp)
+
+	checksum := md5Sum
+	if request.HashAlgo != messages.HashAlgo_MD5 {
+		if hasher, err := util.NewHash(request.HashAlgo); err == nil {
+			if src, err := os.Open(fileName); err == nil {
+				io.Copy(hasher, src)
+				src.Close()
+				checksum = hasher.Sum(nil)
+			}
+		} else {
+			log.Println("Unsupported hash algorithm, falling back to MD5:", err)
+		}
+	}
 	msgHandler.SendChecksumVerification(checksum)
 }
 
 func handleClient(msgHandler *messages.MessageHandler) {
 	defer msgHandler.Close()
 
+	pending, ok := negotiateHello(msgHandler)
+	if !ok {
+		return
+	}
+
 	for {
-		wrapper, err := msgHandler.Receive()
-		if err != nil {
-			log.Println(err)
+		var wrapper *messages.Wrapper
+		var err error
+		if pending != nil {
+			wrapper, pending = pending, nil
+		} else {
+			wrapper, err = msgHandler.Receive()
+			if err != nil {
+				log.Println(err)
+			}
 		}
 
 		switch msg := wrapper.Msg.(type) {
@@ -123,6 +214,33 @@ func handleClient(msgHandler *messages.MessageHandler) {
 		case *messages.Wrapper_RetrievalReq:
 			handleRetrieval(msgHandler, msg.RetrievalReq)
 			continue
+		case *messages.Wrapper_DirectoryStorageReq:
+			handleDirectoryStorage(msgHandler, msg.DirectoryStorageReq)
+			continue
+		case *messages.Wrapper_DirectoryRetrievalReq:
+			handleDirectoryRetrieval(msgHandler, msg.DirectoryRetrievalReq)
+			continue
+		case *messages.Wrapper_ChunkedStorageReq:
+			handleChunkedStorage(msgHandler, msg.ChunkedStorageReq)
+			continue
+		case *messages.Wrapper_ResumeReq:
+			handleResume(msgHandler, msg.ResumeReq)
+			continue
+		case *messages.Wrapper_ListReq:
+			handleList(msgHandler, msg.ListReq)
+			continue
+		case *messages.Wrapper_StatReq:
+			handleStat(msgHandler, msg.StatReq)
+			continue
+		case *messages.Wrapper_DeleteReq:
+			handleDelete(msgHandler, msg.DeleteReq)
+			continue
+		case *messages.Wrapper_ParallelStorageReq:
+			handleParallelStorage(msgHandler, msg.ParallelStorageReq)
+			continue
+		case *messages.Wrapper_AttachReq:
+			handleAttach(msgHandler, msg.AttachReq)
+			continue
 		case nil:
 			log.Println("Received an empty message, terminating client")
 			return
@@ -165,6 +283,7 @@ func main() {
 
 	fmt.Println("Listening on port:", port)
 	fmt.Println("Download directory:", dir)
+	startPartSweeper(".", nil)
 	for {
 		if conn, err := listener.Accept(); err == nil {
 			log.Println("Accepted connection", conn.RemoteAddr())